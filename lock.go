@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// dirLock is a simple advisory lock, implemented with an exclusive-create
+// lock file. It serializes fetches of one mod@ver across multiple proxy
+// processes sharing a GOPATH, the way cmd/go/internal/lockedfile
+// serializes the go command itself. While held, a background goroutine
+// refreshes the lock file's mtime so a still-active holder can't have
+// its lock mistaken for stale and stolen by a waiter.
+type dirLock struct {
+	path string
+	stop chan struct{}
+}
+
+func newLock(path string) (*dirLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &dirLock{path: path}, nil
+}
+
+func (l *dirLock) Lock() error {
+	for {
+		f, err := os.OpenFile(l.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(f, "%d", os.Getpid())
+			f.Close()
+			l.stop = make(chan struct{})
+			go l.keepFresh()
+			return nil
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		// A lock is considered stale, and safe to break, only once it
+		// has gone unrefreshed for more than *ttl plus a minute; a live
+		// holder refreshes it every *ttl/2 (see keepFresh).
+		if info, statErr := os.Stat(l.path); statErr == nil && time.Since(info.ModTime()) > *ttl+time.Minute {
+			os.Remove(l.path)
+			continue
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// keepFresh touches the lock file's mtime well inside the staleness
+// window for as long as this holder keeps the lock, so a single "go mod
+// download"/"go list" that legitimately runs close to *ttl never looks
+// abandoned to a waiter.
+func (l *dirLock) keepFresh() {
+	interval := *ttl / 2
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			now := time.Now()
+			os.Chtimes(l.path, now, now)
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+func (l *dirLock) Unlock() error {
+	close(l.stop)
+	return os.Remove(l.path)
+}
@@ -13,10 +13,10 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
 	"golang.org/x/mod/module"
+	"golang.org/x/sync/singleflight"
 )
 
 var (
@@ -54,6 +54,22 @@ func init() {
 
 func main() {
 	flag.Parse()
+
+	s, err := newSumdbServer(*sumdbKeyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sumDB = s
+	http.Handle("/sumdb/", sumdbHandler(sumDB))
+
+	if *authFile != "" {
+		creds, err := loadAuthFile(*authFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		http.Handle("/ul/", uploadHandler(creds))
+	}
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.NotFound(w, r)
@@ -61,6 +77,16 @@ func main() {
 		}
 
 		path := r.URL.Path[len("/"):]
+		if enc := strings.TrimSuffix(path, "/@latest"); enc != path {
+			mod, err := module.UnescapePath(enc)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			serveLatest(w, r, mod)
+			return
+		}
+
 		i := strings.Index(path, "/@v/")
 		if i < 0 {
 			http.NotFound(w, r)
@@ -105,11 +131,54 @@ func serveMod(w http.ResponseWriter, r *http.Request, mod, ver, ext string) {
 	path, err := fetchPath(mod, ver, ext)
 	if err != nil {
 		log.Println("[ERR]", r.URL.Path, "->", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	} else {
-		log.Println("[OK]", r.URL.Path, "->", path)
-		http.ServeFile(w, r, path)
+		if isNotFoundErr(err) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
 	}
+	log.Println("[OK]", r.URL.Path, "->", path)
+	http.ServeFile(w, r, path)
+}
+
+func serveLatest(w http.ResponseWriter, r *http.Request, mod string) {
+	key := mod + "@latest"
+	if err := negCache.get(key); err != nil {
+		log.Println("[ERR]", r.URL.Path, "->", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var m *moduleJSON
+	err := withModLock(mod, key, func() (err error) {
+		m, err = modInfo(mod, "latest")
+		return err
+	})
+	if err != nil {
+		log.Println("[ERR]", r.URL.Path, "->", err)
+		if isNotFoundErr(err) {
+			negCache.put(key, err)
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// "go mod download -json" doesn't report a timestamp, but the .info
+	// file it leaves in the cache does: it's just {"Version","Time"}.
+	info := struct {
+		Version string
+		Time    string
+	}{Version: m.Version}
+	if b, err := ioutil.ReadFile(m.Info); err == nil {
+		json.Unmarshal(b, &info)
+	}
+
+	log.Println("[OK]", r.URL.Path, "->", info.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
 }
 
 func fetchPath(mod, ver, ext string) (string, error) {
@@ -119,8 +188,24 @@ func fetchPath(mod, ver, ext string) (string, error) {
 	}
 
 	if ext == "list" {
-		err = fetchMod(mod, "latest")
+		key := mod + "@list"
+		if err := negCache.get(key); err != nil {
+			return "", err
+		}
+
+		var versions []string
+		err := withModLock(mod, key, func() (err error) {
+			versions, err = modVersions(mod)
+			return err
+		})
 		if err != nil {
+			if isNotFoundErr(err) {
+				negCache.put(key, err)
+			}
+			return "", err
+		}
+
+		if err := ioutil.WriteFile(path, []byte(strings.Join(versions, "\n")), os.ModePerm); err != nil {
 			return "", err
 		}
 		return path, nil
@@ -130,50 +215,88 @@ func fetchPath(mod, ver, ext string) (string, error) {
 		return path, nil
 	}
 
-	m, err := modInfo(mod, ver)
-	if err != nil {
+	key := mod + "@" + ver
+	if err := negCache.get(key); err != nil {
 		return "", err
 	}
 
-	if strings.HasPrefix(m.Error, "unknown revision") {
-		return "", errors.New(m.Error)
+	var m *moduleJSON
+	err = withModLock(mod, key, func() (err error) {
+		m, err = modInfo(mod, ver)
+		return err
+	})
+	if err != nil {
+		if isNotFoundErr(err) {
+			negCache.put(key, err)
+		}
+		return "", err
 	}
 
+	// "go mod download" already wrote .info/.mod/.zip into the cache on
+	// success, so the path it reports for ext should exist by now.
 	path, ok := map[string]string{
 		".info": m.Info,
 		".mod":  m.GoMod,
 		".zip":  m.Zip,
 	}[ext]
-	if ok && isExist(path) {
-		return path, nil
-	}
-
-	path, err = modPath(mod, m.Version, ext)
-	if err != nil {
-		return "", err
+	if !ok || isNotExist(path) {
+		return "", errors.New("go mod download did not produce " + ext + " for " + mod + "@" + ver)
 	}
+	return path, nil
+}
 
-	if isNotExist(path) {
-		err = fetchMod(mod, m.Version)
+var fetchGroup singleflight.Group
+
+// withModLock runs fn with two layers of serialization around it: a
+// fetchGroup.Do keyed on key so concurrent in-process requests for the
+// same mod@ver, mod@latest, or mod's version list coalesce into a single
+// call, and a dirLock scoped to that same key so other proxy processes
+// sharing this GOPATH do not race the same "go mod download"/"go list"
+// invocation. The lock is per key, not per module,
+// so fetches of distinct versions of the same module still run in
+// parallel.
+func withModLock(mod, key string, fn func() error) error {
+	_, err, _ := fetchGroup.Do(key, func() (interface{}, error) {
+		path, err := lockPath(mod, key)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-	}
-	return path, nil
-}
+		lock, err := newLock(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := lock.Lock(); err != nil {
+			return nil, err
+		}
+		defer lock.Unlock()
 
-var fetchLock sync.Map
+		return nil, fn()
+	})
+	return err
+}
 
-func fetchMod(mod, ver string) error {
-	v, ok := fetchLock.Load(mod)
-	if !ok {
-		v, _ = fetchLock.LoadOrStore(mod, &sync.Mutex{})
+// lockPath derives a lock file path from mod's cache directory that is
+// unique to key (mod@ver, mod@latest, or mod@list), e.g.
+// lockPath("rsc.io/quote", "rsc.io/quote@v1.0.0") ->
+// $download/rsc.io/quote/@v/.v1.0.0.lock.
+func lockPath(mod, key string) (string, error) {
+	dir, err := modDir(mod)
+	if err != nil {
+		return "", err
 	}
-	v.(*sync.Mutex).Lock()
-	defer v.(*sync.Mutex).Unlock()
+	suffix := strings.Replace(strings.TrimPrefix(key, mod+"@"), "/", "_", -1)
+	return filepath.Join(dir, "@v", "."+suffix+".lock"), nil
+}
 
-	_, err := runCmd("go", "get", "-d", mod+"@"+ver)
-	return err
+// isNotFoundErr reports whether err looks like the go command telling us
+// a module or version genuinely does not exist, as opposed to a
+// transient network or timeout failure — only the former is safe to
+// remember in negCache.
+func isNotFoundErr(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "unknown revision") ||
+		strings.Contains(msg, "no matching versions") ||
+		strings.Contains(msg, "not found")
 }
 
 type moduleJSON struct {
@@ -206,9 +329,47 @@ func modInfo(mod, ver string) (*moduleJSON, error) {
 	return nil, errors.New("unexpected")
 }
 
+func modVersions(mod string) ([]string, error) {
+	b, err := runCmd("go", "list", "-m", "-versions", "-json", mod+"@latest")
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(b); i++ {
+		if b[i] == '{' {
+			var list struct {
+				Versions []string
+			}
+			if err := json.Unmarshal(b[i:], &list); err != nil {
+				return nil, err
+			}
+			return list.Versions, nil
+		}
+	}
+	return nil, errors.New("unexpected")
+}
+
+// modDir returns the on-disk cache directory for mod, e.g.
+// modDir("github.com/Foo/bar") -> $download/github.com/!foo/bar. The
+// module path must be escaped with module.EscapePath before it is split
+// on "/" and joined with the OS separator, not after, so that
+// case-insensitive filesystems still keep modules like
+// github.com/Sirupsen/logrus and github.com/sirupsen/logrus in distinct
+// directories.
+func modDir(mod string) (string, error) {
+	escaped, err := module.EscapePath(mod)
+	if err != nil {
+		return "", err
+	}
+	path := strings.Replace(escaped, "/", string(filepath.Separator), -1)
+	return filepath.Join(download, path), nil
+}
+
+// modPath maps a module path, version, and file extension onto the
+// on-disk cache layout under download, e.g.
+// modPath("github.com/Foo/bar", "v1.0.0", ".mod")
+// -> $download/github.com/!foo/bar/@v/v1.0.0.mod.
 func modPath(mod, ver, ext string) (string, error) {
-	path := filepath.Join(strings.Replace(mod, "/", string(filepath.Separator), -1))
-	path, err := module.EscapePath(path)
+	dir, err := modDir(mod)
 	if err != nil {
 		return "", err
 	}
@@ -217,9 +378,9 @@ func modPath(mod, ver, ext string) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		return filepath.Join(download, path, "@v", ver) + ext, nil
+		return filepath.Join(dir, "@v", ver) + ext, nil
 	}
-	return filepath.Join(download, path, "@v", ext), nil
+	return filepath.Join(dir, "@v", ext), nil
 }
 
 type runError struct {
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+// TestModPathCaseSafe checks that module paths which differ only in case
+// land in distinct cache directories, as required on case-insensitive
+// filesystems.
+func TestModPathCaseSafe(t *testing.T) {
+	download = "/cache"
+
+	lower, err := modPath("github.com/sirupsen/logrus", "v1.0.0", ".mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upper, err := modPath("github.com/Sirupsen/logrus", "v1.0.0", ".mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lower == upper {
+		t.Fatalf("modPath collides for case-differing module paths: %q", lower)
+	}
+}
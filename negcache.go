@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"sync"
+	"time"
+)
+
+var negTTL = flag.Duration("neg-ttl", 5*time.Minute, "how long to remember a \"mod@ver does not exist\" failure before retrying upstream")
+
+// negativeCache remembers recent "unknown revision" failures so that a
+// burst of requests for a module that genuinely does not exist does not
+// re-invoke go mod download/go list on every single one.
+type negativeCache struct {
+	mu      sync.Mutex
+	entries map[string]negativeEntry
+}
+
+type negativeEntry struct {
+	err     error
+	expires time.Time
+}
+
+var negCache = &negativeCache{entries: map[string]negativeEntry{}}
+
+func (c *negativeCache) get(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil
+	}
+	return e.err
+}
+
+func (c *negativeCache) put(key string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = negativeEntry{err: err, expires: time.Now().Add(*negTTL)}
+}
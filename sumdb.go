@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/sumdb/note"
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+var sumdbKeyPath = flag.String("sumdb-key", "", "path to the sumdb signing key (generated on first run if empty)")
+
+// sumDB is the process-wide checksum database, wired up in main once the
+// signing key is loaded. If it cannot be initialized, main exits with
+// the error instead of serving.
+var sumDB *sumdbServer
+
+// sumdbServer implements sumdb.ServerOps on top of an append-only,
+// locally signed transparency log. Records are "h1:" hashes computed
+// from the module zip and go.mod already cached by the proxy, so the
+// log only ever grows as a side effect of modules this server has
+// already fetched.
+type sumdbServer struct {
+	name   string
+	signer note.Signer
+	dir    string
+
+	mu      sync.Mutex
+	records [][]byte
+	hashes  []tlog.Hash
+	lookup  map[string]int64
+}
+
+func newSumdbServer(keyPath string) (*sumdbServer, error) {
+	signer, err := loadOrCreateSumdbKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(download, "sumdb", signer.Name())
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	s := &sumdbServer{
+		name:   signer.Name(),
+		signer: signer,
+		dir:    dir,
+		lookup: map[string]int64{},
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func loadOrCreateSumdbKey(path string) (note.Signer, error) {
+	if path == "" {
+		path = filepath.Join(download, "sumdb", "key")
+	}
+
+	if b, err := ioutil.ReadFile(path); err == nil {
+		return note.NewSigner(strings.TrimSpace(string(b)))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "localhost"
+	}
+
+	skey, vkey, err := note.GenerateKey(rand.Reader, hostname+"-sumdb")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, []byte(skey), 0600); err != nil {
+		return nil, err
+	}
+	// The verifier key is the public half clients need to set GOSUMDB
+	// to this server; persist it next to the signing key and print it
+	// once so it isn't lost after the first run.
+	vkeyPath := path + ".pub"
+	if err := ioutil.WriteFile(vkeyPath, []byte(vkey), 0644); err != nil {
+		return nil, err
+	}
+	log.Printf("sumdb: generated new key, set GOSUMDB=%s (also saved to %s)", vkey, vkeyPath)
+	return note.NewSigner(skey)
+}
+
+// recordsPath and hashesPath hold the log's persistent state: recordsPath
+// is a sequence of length-prefixed record blobs, hashesPath a flat run of
+// 32-byte tlog hashes, one per tlog.StoredHashIndex slot.
+func (s *sumdbServer) recordsPath() string { return filepath.Join(s.dir, "records") }
+func (s *sumdbServer) hashesPath() string  { return filepath.Join(s.dir, "hashes") }
+
+func (s *sumdbServer) load() error {
+	hb, err := ioutil.ReadFile(s.hashesPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for len(hb) >= tlog.HashSize {
+		var h tlog.Hash
+		copy(h[:], hb)
+		s.hashes = append(s.hashes, h)
+		hb = hb[tlog.HashSize:]
+	}
+
+	rb, err := ioutil.ReadFile(s.recordsPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for len(rb) > 0 {
+		if len(rb) < 4 {
+			break
+		}
+		n := binary.BigEndian.Uint32(rb)
+		rb = rb[4:]
+		if uint32(len(rb)) < n {
+			break
+		}
+		record := rb[:n]
+		rb = rb[n:]
+
+		id := int64(len(s.records))
+		s.records = append(s.records, record)
+		if mod, ver, ok := recordKey(record); ok {
+			s.lookup[mod+"@"+ver] = id
+		}
+	}
+	return nil
+}
+
+// recordKey extracts "<module> <version>" from the first line of a sumdb
+// record, e.g. "rsc.io/sampler v1.3.0 h1:...=".
+func recordKey(record []byte) (mod, ver string, ok bool) {
+	line := record
+	if i := strings.IndexByte(string(line), '\n'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 {
+		return "", "", false
+	}
+	return fields[0], fields[1], true
+}
+
+func (s *sumdbServer) appendLocked(record []byte) (int64, error) {
+	id := int64(len(s.records))
+	newHashes, err := tlog.StoredHashes(id, record, sliceHashReader(s.hashes))
+	if err != nil {
+		return 0, err
+	}
+
+	rf, err := os.OpenFile(s.recordsPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return 0, err
+	}
+	defer rf.Close()
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(record)))
+	if _, err := rf.Write(length[:]); err != nil {
+		return 0, err
+	}
+	if _, err := rf.Write(record); err != nil {
+		return 0, err
+	}
+
+	hf, err := os.OpenFile(s.hashesPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return 0, err
+	}
+	defer hf.Close()
+	for _, h := range newHashes {
+		if _, err := hf.Write(h[:]); err != nil {
+			return 0, err
+		}
+	}
+
+	s.records = append(s.records, record)
+	s.hashes = append(s.hashes, newHashes...)
+	return id, nil
+}
+
+// ensureRecord returns the log index for mod@ver, computing and appending
+// a fresh h1: record from the cached zip and go.mod the first time it is
+// looked up.
+func (s *sumdbServer) ensureRecord(mod, ver string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := mod + "@" + ver
+	if id, ok := s.lookup[key]; ok {
+		return id, nil
+	}
+
+	zipPath, err := modPath(mod, ver, ".zip")
+	if err != nil {
+		return 0, err
+	}
+	zipHash, err := dirhash.HashZip(zipPath, dirhash.Hash1)
+	if err != nil {
+		return 0, err
+	}
+
+	goModPath, err := modPath(mod, ver, ".mod")
+	if err != nil {
+		return 0, err
+	}
+	goModHash, err := dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return os.Open(goModPath)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	record := []byte(mod + " " + ver + " " + zipHash + "\n" + mod + " " + ver + "/go.mod " + goModHash + "\n")
+	id, err := s.appendLocked(record)
+	if err != nil {
+		return 0, err
+	}
+	s.lookup[key] = id
+	return id, nil
+}
+
+func (s *sumdbServer) Signed(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	n := int64(len(s.records))
+	hashes := sliceHashReader(append([]tlog.Hash(nil), s.hashes...))
+	s.mu.Unlock()
+
+	var root tlog.Hash
+	var err error
+	if n > 0 {
+		root, err = tlog.TreeHash(n, hashes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	text := string(tlog.FormatTree(tlog.Tree{N: n, Hash: root}))
+	return note.Sign(&note.Note{Text: text}, s.signer)
+}
+
+func (s *sumdbServer) ReadRecords(ctx context.Context, id, n int64) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < 0 || n < 0 || id+n > int64(len(s.records)) {
+		return nil, errOutOfRange
+	}
+	return append([][]byte(nil), s.records[id:id+n]...), nil
+}
+
+func (s *sumdbServer) Lookup(ctx context.Context, mod module.Version) (int64, error) {
+	return s.ensureRecord(mod.Path, mod.Version)
+}
+
+func (s *sumdbServer) ReadTileData(ctx context.Context, t tlog.Tile) ([]byte, error) {
+	s.mu.Lock()
+	hashes := sliceHashReader(append([]tlog.Hash(nil), s.hashes...))
+	s.mu.Unlock()
+	return tlog.ReadTileData(t, hashes)
+}
+
+var errOutOfRange = errors.New("sumdb: record range out of bounds")
+
+// sliceHashReader answers tlog.HashReader requests directly out of an
+// in-memory slice of stored hashes.
+type sliceHashReader []tlog.Hash
+
+func (r sliceHashReader) ReadHashes(indexes []int64) ([]tlog.Hash, error) {
+	out := make([]tlog.Hash, len(indexes))
+	for i, x := range indexes {
+		if x < 0 || int(x) >= len(r) {
+			return nil, errOutOfRange
+		}
+		out[i] = r[x]
+	}
+	return out, nil
+}
+
+// sumdbHandler serves the /sumdb/<name>/... routes described by
+// https://golang.org/design/25530-sumdb, delegating lookup/tile/latest
+// requests to sumdb.Server and answering /supported directly.
+func sumdbHandler(s *sumdbServer) http.Handler {
+	srv := sumdb.NewServer(s)
+	prefix := "sumdb/" + s.name
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path[len("/"):]
+		rest := strings.TrimPrefix(path, prefix)
+		if rest == path {
+			http.NotFound(w, r)
+			return
+		}
+
+		if rest == "/supported" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = rest
+		srv.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/zip"
+)
+
+var authFile = flag.String("auth", "", "path to a \"user:bcrypt-hash\" credentials file; enables PUT /ul/<module>/@v/<version>.zip")
+
+// uploadHandler accepts private modules over PUT/POST, following mir's
+// /ul/<module>/@v/<version>.zip convention, and installs them into the
+// same on-disk cache layout GET requests are served from. The request
+// must be a multipart form with "zip", "mod", and "info" parts and must
+// authenticate against creds with HTTP basic auth.
+func uploadHandler(creds map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+
+		if !checkAuth(w, r, creds) {
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/ul/")
+		i := strings.Index(path, "/@v/")
+		if path == r.URL.Path || i < 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		enc, file := path[:i], path[i+len("/@v/"):]
+		mod, err := module.UnescapePath(enc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		encVers := strings.TrimSuffix(file, ".zip")
+		if encVers == file {
+			http.NotFound(w, r)
+			return
+		}
+		ver, err := module.UnescapeVersion(encVers)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := uploadMod(r, mod, ver); err != nil {
+			log.Println("[ERR]", r.URL.Path, "->", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Println("[OK]", r.URL.Path, "->", mod+"@"+ver)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func uploadMod(r *http.Request, mod, ver string) error {
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		return err
+	}
+
+	zipTmp, err := saveFormFile(r, "zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(zipTmp)
+
+	if _, err := zip.CheckZip(module.Version{Path: mod, Version: ver}, zipTmp); err != nil {
+		return err
+	}
+
+	goModTmp, err := saveFormFile(r, "mod")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(goModTmp)
+
+	infoTmp, err := saveFormFile(r, "info")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(infoTmp)
+
+	for ext, tmp := range map[string]string{".zip": zipTmp, ".mod": goModTmp, ".info": infoTmp} {
+		dst, err := modPath(mod, ver, ext)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+			return err
+		}
+		if err := copyFile(tmp, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveFormFile(r *http.Request, field string) (string, error) {
+	f, _, err := r.FormFile(field)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	tmp, err := ioutil.TempFile("", "mod-upload-")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, f); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// checkAuth verifies the request's HTTP basic auth credentials against
+// creds (user -> bcrypt hash), writing a 401 and returning false if they
+// are missing or wrong.
+func checkAuth(w http.ResponseWriter, r *http.Request, creds map[string]string) bool {
+	user, pass, ok := r.BasicAuth()
+	if ok {
+		hash, found := creds[user]
+		if found && bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil {
+			return true
+		}
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="mod"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+// loadAuthFile reads a "user:bcrypt-hash" credentials file, one entry per
+// line, blank lines and "#"-prefixed comments ignored.
+func loadAuthFile(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(string(b)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return nil, errInvalidAuthLine(line)
+		}
+		creds[line[:i]] = line[i+1:]
+	}
+	return creds, scanner.Err()
+}
+
+type errInvalidAuthLine string
+
+func (e errInvalidAuthLine) Error() string { return "invalid auth line: " + string(e) }